@@ -0,0 +1,94 @@
+// Package api serves the router's internal, operator-facing HTTP endpoints
+// on a port separate from the public ingest listener.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/logging"
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+var log = logging.For("api")
+
+// HTTPListenerConfig holds the configs for the internal api daemon.
+type HTTPListenerConfig struct {
+	Addr     string
+	Port     string
+	TomlConf config.Conf
+	APIConf  config.APIKeyMap
+
+	// PromEnabled registers a /metrics scrape endpoint backed by PromGatherer.
+	// Kept off the public ingest listener so scraping can't be abused to
+	// impact write throughput.
+	PromEnabled  bool
+	PromGatherer prometheus.Gatherer
+
+	// Prom, when set, lets handlers (e.g. a DLQ purge) keep prometheus
+	// collectors owned by the writer in sync with operator actions taken
+	// through this api.
+	Prom *stats.Prometheus
+}
+
+// customersHandler lists the configured customers and which backend version
+// each one routes to, without leaking api keys or tokens.
+func customersHandler(w http.ResponseWriter, req *http.Request, c *HTTPListenerConfig) {
+	type customer struct {
+		Name    string `json:"name"`
+		Backend string `json:"backend"`
+	}
+	out := make([]customer, 0, len(c.TomlConf.Customers))
+	for _, cust := range c.TomlConf.Customers {
+		out = append(out, customer{Name: cust.Name, Backend: string(cust.Version())})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// httpHandlers registers the internal api routes.
+func httpHandlers(h *http.ServeMux, c *HTTPListenerConfig) *http.ServeMux {
+	h.HandleFunc("/customers", func(w http.ResponseWriter, req *http.Request) { customersHandler(w, req, c) })
+	h.HandleFunc("/dlq", func(w http.ResponseWriter, req *http.Request) { dlqHandler(w, req, c) })
+	if c.PromEnabled && c.PromGatherer != nil {
+		h.Handle("/metrics", promhttp.HandlerFor(c.PromGatherer, promhttp.HandlerOpts{}))
+	}
+	return h
+}
+
+// HTTPListener starts the internal, operator-facing api daemon.
+func HTTPListener(c *HTTPListenerConfig) {
+	h := http.NewServeMux()
+	h = httpHandlers(h, c)
+
+	addr := c.Addr + ":" + c.Port
+	log.Infof("InfluxDB Router api listening on http %s\n", addr)
+	if err := http.ListenAndServe(addr, h); err != nil {
+		log.Fatalf("ListenAndServe: %s\n", err)
+	}
+}
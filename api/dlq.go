@@ -0,0 +1,133 @@
+// Package api serves the router's internal, operator-facing HTTP endpoints
+// on a port separate from the public ingest listener.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+// dlqEntry summarizes a dead-lettered batch without including its body, so
+// listing a customer's DLQ doesn't ship potentially large payloads back.
+type dlqEntry struct {
+	MessageID string `json:"message_id"`
+	Customer  string `json:"customer"`
+	Attempts  int    `json:"attempts"`
+}
+
+// dlqHandler lists or purges dead-lettered batches for a customer.
+//
+//	GET  /dlq?customer=acme    list dead-lettered batches for acme
+//	DELETE /dlq?customer=acme  purge dead-lettered batches for acme
+func dlqHandler(w http.ResponseWriter, req *http.Request, c *HTTPListenerConfig) {
+	if c.TomlConf.DLQDir == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	customer := req.URL.Query().Get("customer")
+	if customer == "" {
+		http.Error(w, "missing required query param: customer", http.StatusBadRequest)
+		return
+	}
+	cust, ok := findCustomer(c.TomlConf.Customers, customer)
+	if !ok {
+		http.Error(w, "unknown customer", http.StatusBadRequest)
+		return
+	}
+
+	dir := filepath.Join(c.TomlConf.DLQDir, customer)
+
+	switch req.Method {
+	case http.MethodGet:
+		listDLQ(w, dir, customer)
+	case http.MethodDelete:
+		purgeDLQ(w, dir, cust, c.Prom)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func listDLQ(w http.ResponseWriter, dir, customer string) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]dlqEntry{})
+		return
+	}
+	if err != nil {
+		log.Errorf("error reading dlq dir %s: %v", dir, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]dlqEntry, 0, len(entries))
+	for _, e := range entries {
+		buf, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			log.Errorf("error reading dlq file %s: %v", e.Name(), err)
+			continue
+		}
+		var rec backends.DLQRecord
+		if err := json.Unmarshal(buf, &rec); err != nil {
+			log.Errorf("error decoding dlq file %s: %v", e.Name(), err)
+			continue
+		}
+		out = append(out, dlqEntry{MessageID: rec.MessageID, Customer: rec.Customer, Attempts: rec.Attempts})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// findCustomer looks up name among the router's configured customers,
+// guarding dlqHandler against a customer query param that could otherwise be
+// used to traverse outside DLQDir, and giving purgeDLQ the customer's
+// backend version for its prometheus label.
+func findCustomer(customers []config.Customers, name string) (config.Customers, bool) {
+	for _, cust := range customers {
+		if cust.Name == name {
+			return cust, true
+		}
+	}
+	return config.Customers{}, false
+}
+
+func purgeDLQ(w http.ResponseWriter, dir string, cust config.Customers, prom *stats.Prometheus) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Errorf("error purging dlq dir %s: %v", dir, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if prom != nil {
+		prom.DLQSize.WithLabelValues(cust.Name, string(cust.Version())).Set(0)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
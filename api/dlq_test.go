@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+func writeDLQFile(t *testing.T, dir string, rec backends.DLQRecord) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, rec.MessageID+".json"), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDLQHandlerList(t *testing.T) {
+	dlqDir := t.TempDir()
+	writeDLQFile(t, filepath.Join(dlqDir, "acme"), backends.DLQRecord{MessageID: "msg-1", Customer: "acme", APIKey: "key1", Attempts: 5})
+
+	c := &HTTPListenerConfig{TomlConf: config.Conf{DLQDir: dlqDir, Customers: []config.Customers{{Name: "acme"}}}}
+
+	req := httptest.NewRequest(http.MethodGet, "/dlq?customer=acme", nil)
+	w := httptest.NewRecorder()
+	dlqHandler(w, req, c)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+
+	var entries []dlqEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if len(entries) != 1 || entries[0].MessageID != "msg-1" || entries[0].Attempts != 5 {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestDLQHandlerPurge(t *testing.T) {
+	dlqDir := t.TempDir()
+	custDir := filepath.Join(dlqDir, "acme")
+	writeDLQFile(t, custDir, backends.DLQRecord{MessageID: "msg-1", Customer: "acme"})
+
+	c := &HTTPListenerConfig{TomlConf: config.Conf{DLQDir: dlqDir, Customers: []config.Customers{{Name: "acme"}}}}
+
+	req := httptest.NewRequest(http.MethodDelete, "/dlq?customer=acme", nil)
+	w := httptest.NewRecorder()
+	dlqHandler(w, req, c)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want 204", w.Code)
+	}
+	if _, err := os.Stat(custDir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", custDir)
+	}
+}
+
+func TestDLQHandlerMissingCustomer(t *testing.T) {
+	c := &HTTPListenerConfig{TomlConf: config.Conf{DLQDir: t.TempDir()}}
+	req := httptest.NewRequest(http.MethodGet, "/dlq", nil)
+	w := httptest.NewRecorder()
+	dlqHandler(w, req, c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", w.Code)
+	}
+}
+
+func TestDLQHandlerRejectsUnknownCustomer(t *testing.T) {
+	dlqDir := t.TempDir()
+	outside := filepath.Join(filepath.Dir(dlqDir), "outside")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &HTTPListenerConfig{TomlConf: config.Conf{DLQDir: dlqDir, Customers: []config.Customers{{Name: "acme"}}}}
+
+	req := httptest.NewRequest(http.MethodDelete, "/dlq?customer=../outside", nil)
+	w := httptest.NewRecorder()
+	dlqHandler(w, req, c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", w.Code)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("expected %s to be left untouched, got: %v", outside, err)
+	}
+}
+
+func TestDLQHandlerPurgeResetsDLQSizeGauge(t *testing.T) {
+	dlqDir := t.TempDir()
+	custDir := filepath.Join(dlqDir, "acme")
+	writeDLQFile(t, custDir, backends.DLQRecord{MessageID: "msg-1", Customer: "acme"})
+
+	prom := stats.NewPrometheus()
+	prom.DLQSize.WithLabelValues("acme", "v1").Add(3)
+
+	c := &HTTPListenerConfig{
+		TomlConf: config.Conf{DLQDir: dlqDir, Customers: []config.Customers{{Name: "acme"}}},
+		Prom:     prom,
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/dlq?customer=acme", nil)
+	w := httptest.NewRecorder()
+	dlqHandler(w, req, c)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want 204", w.Code)
+	}
+	if got := testutil.ToFloat64(prom.DLQSize.WithLabelValues("acme", "v1")); got != 0 {
+		t.Errorf("got DLQSize=%v after purge, want 0", got)
+	}
+}
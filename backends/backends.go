@@ -0,0 +1,172 @@
+// Package backends defines the data that flows between the HTTP listener
+// and the outbound InfluxDB writers.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package backends
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrUnsupportedEncoding is returned by Decode when no Decoder is registered
+// for the requested Content-Encoding.
+var ErrUnsupportedEncoding = errors.New("unsupported content-encoding")
+
+// ErrDecodedTooLarge is returned by DecodeLimited when decompressing body
+// would produce more than the given limit of data.
+var ErrDecodedTooLarge = errors.New("decoded payload exceeds maximum allowed size")
+
+// Payload is a single compressed batch of line-protocol points queued for
+// delivery to a customer's InfluxDB backend.
+type Payload struct {
+	MessageID string
+	Body      []byte
+	APIKey    string
+	// Encoding is the lowercased Content-Encoding the batch arrived with,
+	// e.g. "gzip", "snappy", "zstd" or "identity".
+	Encoding string
+}
+
+// DLQRecord is a Payload that exhausted its retry attempts and was persisted
+// to the dead-letter directory for later replay.
+type DLQRecord struct {
+	MessageID string `json:"message_id"`
+	Customer  string `json:"customer"`
+	APIKey    string `json:"api_key"`
+	Body      []byte `json:"body"`
+	Encoding  string `json:"encoding"`
+	Attempts  int    `json:"attempts"`
+}
+
+// Decoder returns a reader that decompresses r.
+type Decoder func(r io.Reader) (io.Reader, error)
+
+// Decoders maps a lowercased Content-Encoding value to the Decoder that
+// decompresses it. "" and "identity" are both accepted as uncompressed.
+var Decoders = map[string]Decoder{
+	"gzip":     gzipDecoder,
+	"snappy":   snappyDecoder,
+	"zstd":     zstdDecoder,
+	"identity": identityDecoder,
+	"":         identityDecoder,
+}
+
+func gzipDecoder(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// snappyDecoder decodes block-format snappy, the format snappy.Encode
+// produces. This is not the streaming/framing format snappy.NewReader
+// expects; the two are not interchangeable.
+func snappyDecoder(r io.Reader) (io.Reader, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(decoded), nil
+}
+
+func zstdDecoder(r io.Reader) (io.Reader, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+func identityDecoder(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+// Decode fully decompresses body using the Decoder registered for encoding,
+// returning an error if the encoding is unknown or the body is malformed.
+func Decode(encoding string, body []byte) ([]byte, error) {
+	decoder, ok := Decoders[encoding]
+	if !ok {
+		return nil, ErrUnsupportedEncoding
+	}
+	r, err := decoder(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, r); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// DecodeLimited is like Decode but aborts with ErrDecodedTooLarge instead of
+// fully decompressing body when the decompressed output would exceed
+// maxBytes. Intended for the ingest path, where body is still
+// attacker-controlled, so a crafted batch can't be used to decompress-bomb
+// the router into exhausting memory.
+func DecodeLimited(encoding string, body []byte, maxBytes int64) ([]byte, error) {
+	decoder, ok := Decoders[encoding]
+	if !ok {
+		return nil, ErrUnsupportedEncoding
+	}
+	r, err := decoder(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, io.LimitReader(r, maxBytes+1)); err != nil {
+		return nil, err
+	}
+	if int64(out.Len()) > maxBytes {
+		return nil, ErrDecodedTooLarge
+	}
+	return out.Bytes(), nil
+}
+
+// GzipEncode gzip-compresses body. The writer uses it to re-encode a batch
+// that arrived in an encoding its downstream InfluxDB backend doesn't accept
+// as a pass-through.
+func GzipEncode(body []byte) ([]byte, error) {
+	var out bytes.Buffer
+	zw := gzip.NewWriter(&out)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
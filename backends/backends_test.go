@@ -0,0 +1,98 @@
+package backends
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecodeRoundTrip(t *testing.T) {
+	want := []byte("cpu,host=a value=1")
+
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	if _, err := zw.Write(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zstdEnc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]byte{
+		"gzip":     gzipped.Bytes(),
+		"snappy":   snappy.Encode(nil, want),
+		"zstd":     zstdEnc.EncodeAll(want, nil),
+		"identity": want,
+		"":         want,
+	}
+
+	for encoding, body := range cases {
+		got, err := Decode(encoding, body)
+		if err != nil {
+			t.Errorf("Decode(%q): unexpected error: %v", encoding, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Decode(%q) = %q, want %q", encoding, got, want)
+		}
+	}
+}
+
+func TestDecodeUnsupportedEncoding(t *testing.T) {
+	_, err := Decode("brotli", []byte("whatever"))
+	if err != ErrUnsupportedEncoding {
+		t.Errorf("got error %v, want ErrUnsupportedEncoding", err)
+	}
+}
+
+func TestDecodeLimitedWithinLimit(t *testing.T) {
+	want := []byte("cpu,host=a value=1")
+	gzipped, err := GzipEncode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DecodeLimited("gzip", gzipped, int64(len(want)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecodeLimitedRejectsOversizedOutput(t *testing.T) {
+	want := []byte("cpu,host=a value=1")
+	gzipped, err := GzipEncode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DecodeLimited("gzip", gzipped, int64(len(want))-1)
+	if err != ErrDecodedTooLarge {
+		t.Errorf("got error %v, want ErrDecodedTooLarge", err)
+	}
+}
+
+func TestGzipEncode(t *testing.T) {
+	want := []byte("cpu,host=a value=1")
+	gzipped, err := GzipEncode(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := Decode("gzip", gzipped)
+	if err != nil {
+		t.Fatalf("decoding re-encoded body: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
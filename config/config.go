@@ -0,0 +1,220 @@
+// Package config handles loading of the router's config.toml and building
+// the derived lookup tables the rest of the router uses at runtime.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// InfluxVersion identifies which InfluxDB write protocol a customer's batches
+// should be routed to.
+type InfluxVersion string
+
+const (
+	// InfluxV1 routes to the legacy /write endpoint.
+	InfluxV1 InfluxVersion = "v1"
+	// InfluxV2 routes to the /api/v2/write endpoint.
+	InfluxV2 InfluxVersion = "v2"
+)
+
+// Customers is a single customer entry as declared in config.toml.
+type Customers struct {
+	Name string `toml:"name"`
+
+	// APIKey is the value clients send in the Service-API-Key header.
+	// Required even when auth-mode is "oidc": clients authenticate with a
+	// bearer token instead of this header, but the router still uses it
+	// internally as the customer routing key, so every customer needs a
+	// unique (placeholder, if never sent by a client) value configured.
+	APIKey string `toml:"api_key"`
+
+	// URL is the InfluxDB v1 write endpoint. When InfluxVersion is "v2" it is
+	// the backend's base address and its path is replaced with /api/v2/write.
+	URL string `toml:"url"`
+	DB  string `toml:"db"`
+
+	// InfluxVersion selects the write protocol. Defaults to v1 when empty.
+	InfluxVersion InfluxVersion `toml:"influx_version"`
+
+	// Token, Org and Bucket are only used when InfluxVersion is "v2".
+	Token  string `toml:"token"`
+	Org    string `toml:"org"`
+	Bucket string `toml:"bucket"`
+	// Precision is optional and defaults to "ns" when unset.
+	Precision string `toml:"precision"`
+
+	SkipSSLCertVerify bool   `toml:"skip_ssl_cert_verify"`
+	ProxyURL          string `toml:"proxy_url"`
+
+	// AllowedEncodings restricts which Content-Encoding values this
+	// customer's clients may use on /write. Empty allows every encoding the
+	// router has a backends.Decoder for (gzip, snappy, zstd, identity).
+	AllowedEncodings []string `toml:"allowed_encodings"`
+
+	// BackendEncodings lists the Content-Encoding values this customer's
+	// InfluxDB backend accepts directly. A batch arriving in a compressed
+	// encoding outside this list is decompressed and re-encoded to gzip before
+	// being forwarded; an identity batch is always forwarded uncompressed.
+	// Empty defaults to gzip only, matching the behavior before encodings
+	// other than gzip were supported.
+	BackendEncodings []string `toml:"backend_encodings"`
+}
+
+// Version returns the customer's resolved InfluxVersion, defaulting to v1.
+func (c Customers) Version() InfluxVersion {
+	if c.InfluxVersion == "" {
+		return InfluxV1
+	}
+	return c.InfluxVersion
+}
+
+// EncodingAllowed reports whether enc (already lowercased) may be used by
+// this customer's clients when posting to /write.
+func (c Customers) EncodingAllowed(enc string) bool {
+	if len(c.AllowedEncodings) == 0 {
+		return true
+	}
+	for _, e := range c.AllowedEncodings {
+		if strings.EqualFold(e, enc) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackendSupportsEncoding reports whether this customer's InfluxDB backend
+// accepts enc (already lowercased) as a Content-Encoding pass-through rather
+// than requiring the router to re-encode the batch to gzip first.
+func (c Customers) BackendSupportsEncoding(enc string) bool {
+	if len(c.BackendEncodings) == 0 {
+		return enc == "gzip"
+	}
+	for _, e := range c.BackendEncodings {
+		if strings.EqualFold(e, enc) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conf is the top level representation of config.toml.
+type Conf struct {
+	Customers    []Customers `toml:"customers"`
+	StatsdServer string      `toml:"statsd_server"`
+
+	// RetryInitial and RetryMax are Go duration strings (e.g. "500ms", "30s")
+	// bounding the exponential backoff applied to failed writes.
+	RetryInitial    string  `toml:"retry_initial"`
+	RetryMax        string  `toml:"retry_max"`
+	RetryMultiplier float64 `toml:"retry_multiplier"`
+	// MaxAttempts is how many times a batch is retried before it is
+	// dead-lettered to DLQDir. 0 (or unset) falls back to the default of 5.
+	MaxAttempts int `toml:"max_attempts"`
+	// DLQDir is the directory batches are written to once MaxAttempts is
+	// exceeded. When empty, such batches are logged and dropped.
+	DLQDir string `toml:"dlq_dir"`
+
+	// OIDC configures bearer-token authentication, used when a listener's
+	// auth-mode is set to "oidc" instead of the default api-key header.
+	OIDC OIDCConfig `toml:"oidc"`
+}
+
+// OIDCConfig configures validation of Authorization: Bearer JWTs against an
+// OIDC issuer's published JWKS, and how a verified token maps to a customer.
+type OIDCConfig struct {
+	IssuerURL string `toml:"issuer_url"`
+	Audience  string `toml:"audience"`
+	// JWKSRefreshInterval is a Go duration string (e.g. "1h"). Defaults to
+	// 1h when empty.
+	JWKSRefreshInterval string `toml:"jwks_refresh_interval"`
+	// ClaimName is the JWT claim whose value is matched against a
+	// customer's Name to resolve the request's customer. Defaults to "sub".
+	ClaimName string `toml:"claim_name"`
+}
+
+// NewConfigs reads and decodes the toml config file at path.
+func NewConfigs(path string) (*Conf, error) {
+	var c Conf
+	if _, err := toml.DecodeFile(path, &c); err != nil {
+		return nil, fmt.Errorf("error decoding config file %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// LogConfig renders a human readable summary of the loaded config, masking
+// secrets, suitable for startup logging.
+func (c *Conf) LogConfig() string {
+	var sb strings.Builder
+	sb.WriteString("Loaded config:\n")
+	for _, cust := range c.Customers {
+		sb.WriteString(fmt.Sprintf("  customer=%s version=%s api_key=%s\n", cust.Name, cust.Version(), Mask(cust.APIKey, 4)))
+	}
+	return sb.String()
+}
+
+// APIKeyMap maps an incoming API key to the customer it belongs to.
+type APIKeyMap map[string]Customers
+
+// NewAPIKeyMap builds the APIKeyMap used by the listener to validate and
+// resolve incoming requests. authEnabled/authMode are currently only
+// consulted to decide whether a missing API key is an error (duplicate API
+// keys are always rejected); they are
+// kept as parameters so callers don't need to branch on auth mode themselves.
+// An oidc authMode requires every customer to have an api_key configured
+// regardless of authEnabled: resolveOIDCCustomer still resolves a validated
+// JWT claim to a customer through this map, so a customer with no api_key
+// would otherwise silently drop out of it and every request for them would
+// 401.
+func NewAPIKeyMap(customers []Customers, authEnabled bool, authMode string) (APIKeyMap, error) {
+	m := make(APIKeyMap, len(customers))
+	for _, cust := range customers {
+		if cust.APIKey == "" {
+			if authEnabled {
+				return nil, fmt.Errorf("customer %s has no api_key configured but auth is enabled", cust.Name)
+			}
+			if authMode == "oidc" {
+				return nil, fmt.Errorf("customer %s has no api_key configured; api_key is required for every customer when auth-mode is oidc, even though oidc clients authenticate with a bearer token instead of sending it", cust.Name)
+			}
+			continue
+		}
+		if _, exists := m[cust.APIKey]; exists {
+			return nil, fmt.Errorf("duplicate api_key configured for customer %s", cust.Name)
+		}
+		m[cust.APIKey] = cust
+	}
+	return m, nil
+}
+
+// Mask replaces all but the last n characters of s with asterisks, useful
+// for logging secrets like API keys and tokens without exposing them. A
+// string of n characters or fewer is masked entirely.
+func Mask(s string, n int) string {
+	if len(s) <= n {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-n) + s[len(s)-n:]
+}
@@ -0,0 +1,57 @@
+package config
+
+import "testing"
+
+func TestEncodingAllowed(t *testing.T) {
+	open := Customers{Name: "acme"}
+	if !open.EncodingAllowed("zstd") {
+		t.Error("empty allowed_encodings should allow every encoding")
+	}
+
+	restricted := Customers{Name: "acme", AllowedEncodings: []string{"gzip", "identity"}}
+	if !restricted.EncodingAllowed("gzip") {
+		t.Error("gzip should be allowed")
+	}
+	if restricted.EncodingAllowed("snappy") {
+		t.Error("snappy should not be allowed")
+	}
+}
+
+func TestNewAPIKeyMapRequiresAPIKeyUnderOIDC(t *testing.T) {
+	customers := []Customers{{Name: "acme"}}
+
+	if _, err := NewAPIKeyMap(customers, false, "oidc"); err == nil {
+		t.Error("expected an error for a customer with no api_key under auth-mode=oidc")
+	}
+
+	if _, err := NewAPIKeyMap(customers, false, "from-config"); err != nil {
+		t.Errorf("unexpected error outside oidc mode: %v", err)
+	}
+
+	customers[0].APIKey = "placeholder"
+	m, err := NewAPIKeyMap(customers, false, "oidc")
+	if err != nil {
+		t.Fatalf("unexpected error once api_key is set: %v", err)
+	}
+	if _, ok := m["placeholder"]; !ok {
+		t.Error("expected customer to be present in the api key map")
+	}
+}
+
+func TestBackendSupportsEncoding(t *testing.T) {
+	def := Customers{Name: "acme"}
+	if !def.BackendSupportsEncoding("gzip") {
+		t.Error("gzip should be supported by default")
+	}
+	if def.BackendSupportsEncoding("snappy") {
+		t.Error("snappy should not be supported without backend_encodings configured")
+	}
+
+	configured := Customers{Name: "acme", BackendEncodings: []string{"snappy", "zstd"}}
+	if !configured.BackendSupportsEncoding("snappy") {
+		t.Error("snappy should be supported once configured")
+	}
+	if configured.BackendSupportsEncoding("gzip") {
+		t.Error("gzip should not be supported when not in backend_encodings")
+	}
+}
@@ -0,0 +1,111 @@
+// Package listener provides code for managing incoming http requests.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package listener
+
+import (
+	"net/http"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+// inFlightLimiter bounds the number of requests being actively processed so
+// that a handful of slow InfluxDB backends can't let unbounded concurrent
+// requests pile up in front of them. Requests whose method+path match
+// longRunningRE (e.g. /health, or future streaming/tail endpoints) bypass
+// the limiter entirely.
+type inFlightLimiter struct {
+	sem         chan struct{}
+	longRunning *regexp.Regexp
+	current     int32
+	statsd      *stats.Statsd
+}
+
+// newInFlightLimiter builds a limiter for maxInFlight concurrent requests.
+// A maxInFlight of 0 disables the limit (no semaphore is used and every
+// request passes straight through).
+// longRunningRE, if non-empty, is compiled and matched against "METHOD path"
+// to decide which requests bypass the limiter.
+func newInFlightLimiter(maxInFlight int, longRunningRE string, sd *stats.Statsd) (*inFlightLimiter, error) {
+	var re *regexp.Regexp
+	if longRunningRE != "" {
+		var err error
+		re, err = regexp.Compile(longRunningRE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sem chan struct{}
+	if maxInFlight > 0 {
+		sem = make(chan struct{}, maxInFlight)
+	}
+
+	return &inFlightLimiter{sem: sem, longRunning: re, statsd: sd}, nil
+}
+
+// bypass reports whether req matches the configured long-running request
+// pattern and should skip the in-flight limiter.
+func (l *inFlightLimiter) bypass(req *http.Request) bool {
+	if l.longRunning == nil {
+		return false
+	}
+	return l.longRunning.MatchString(req.Method + " " + req.URL.Path)
+}
+
+// wrap returns next wrapped with the in-flight limiter. On saturation it
+// responds 429 with a Retry-After header instead of calling next.
+func (l *inFlightLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if l.sem == nil || l.bypass(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		select {
+		case l.sem <- struct{}{}:
+			defer func() { <-l.sem }()
+			current := atomic.AddInt32(&l.current, 1)
+			defer func() {
+				remaining := atomic.AddInt32(&l.current, -1)
+				go l.statsd.SendStatsdGaugeMetric("influx_router.inflight.current", int(remaining))
+				if l.statsd.Prom != nil {
+					l.statsd.Prom.InFlight.Set(float64(remaining))
+				}
+			}()
+
+			go l.statsd.SendStatsdCounterMetric("influx_router.inflight.accepted", 1)
+			go l.statsd.SendStatsdGaugeMetric("influx_router.inflight.current", int(current))
+			if l.statsd.Prom != nil {
+				l.statsd.Prom.InFlight.Set(float64(current))
+			}
+			next.ServeHTTP(w, req)
+		default:
+			go l.statsd.SendStatsdCounterMetric("influx_router.inflight.rejected", 1)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			log.Infof("[client %s] rejecting request, %d requests already in flight", req.RemoteAddr, atomic.LoadInt32(&l.current))
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package listener
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+func TestInFlightLimiterRejectsWhenSaturated(t *testing.T) {
+	l, err := newInFlightLimiter(1, "^GET /health$", &stats.Statsd{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	release := make(chan struct{})
+	slow := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/write", nil)
+		w := httptest.NewRecorder()
+		slow.ServeHTTP(w, req)
+		close(done)
+	}()
+
+	// Give the first request time to acquire the semaphore slot.
+	time.Sleep(50 * time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodPost, "/write", nil)
+	w := httptest.NewRecorder()
+	slow.ServeHTTP(w, req)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After header to be set")
+	}
+
+	close(release)
+	<-done
+}
+
+func TestInFlightLimiterBypassesLongRunningRequests(t *testing.T) {
+	l, err := newInFlightLimiter(0, "^GET /health$", &stats.Statsd{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	if !l.bypass(req) {
+		t.Error("expected /health request to bypass the limiter")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/write", nil)
+	if l.bypass(req) {
+		t.Error("expected /write request to not bypass the limiter")
+	}
+}
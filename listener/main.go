@@ -32,6 +32,7 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/rs/xid"
 	"github.com/samitpal/influxdb-router/backends"
@@ -61,13 +62,59 @@ type HTTPListenerConfig struct {
 	APIConfig         config.APIKeyMap
 	HealthCheck       chan bool
 	Statsd            *stats.Statsd
+
+	// MaxRequestsInFlight caps the number of requests processed concurrently.
+	// 0 disables the limit.
+	MaxRequestsInFlight int
+	// LongRunningRequestRE matches "METHOD path" for requests that should
+	// bypass the in-flight limit, e.g. "^GET /health$".
+	LongRunningRequestRE string
+
+	// AuthMode selects how a request's customer is resolved. "" (the
+	// default) reads APIKeyHeaderName. "oidc" instead validates an
+	// Authorization: Bearer JWT against OIDC.
+	AuthMode string
+	OIDC     config.OIDCConfig
+
+	// MaxDecodedBatchBytes bounds how many bytes a batch may decompress to
+	// during the ingest-time decode validation, guarding against a small
+	// crafted snappy/zstd/gzip body decompressing into a much larger one.
+	// 0 falls back to defaultMaxDecodedBatchBytes.
+	MaxDecodedBatchBytes int64
+
+	oidc *oidcValidator
 }
 
+// defaultMaxDecodedBatchBytes is the decompressed-size cap used when
+// MaxDecodedBatchBytes is left unset.
+const defaultMaxDecodedBatchBytes = 64 << 20 // 64 MiB
+
 // httpHandlers has all the routes defined.
 func httpHandlers(h *http.ServeMux, config *HTTPListenerConfig) *http.ServeMux {
-	h.Handle("/write", logHTTPRequest(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { ingest(w, req, config) })))
+	limiter, err := newInFlightLimiter(config.MaxRequestsInFlight, config.LongRunningRequestRE, config.Statsd)
+	if err != nil {
+		log.Fatalf("invalid long_running_request_re: %v", err)
+	}
 
-	h.Handle("/health", logHTTPRequest(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { health(w, config) })))
+	if config.AuthMode == "oidc" {
+		refresh := time.Hour
+		if config.OIDC.JWKSRefreshInterval != "" {
+			d, err := time.ParseDuration(config.OIDC.JWKSRefreshInterval)
+			if err != nil {
+				log.Fatalf("invalid oidc jwks_refresh_interval: %v", err)
+			}
+			refresh = d
+		}
+		v, err := newOIDCValidator(config.OIDC.IssuerURL, config.OIDC.Audience, config.OIDC.ClaimName, refresh)
+		if err != nil {
+			log.Fatalf("error initializing oidc validator: %v", err)
+		}
+		config.oidc = v
+	}
+
+	h.Handle("/write", limiter.wrap(logHTTPRequest(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { ingest(w, req, config) }))))
+
+	h.Handle("/health", limiter.wrap(logHTTPRequest(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { health(w, config) }))))
 	return h
 }
 
@@ -150,14 +197,20 @@ func HTTPListener(config *HTTPListenerConfig) {
 	}
 }
 
+// encodingLabel normalizes a Content-Encoding header value for use as a
+// backends.Decoders lookup key and a statsd/prometheus label, treating a
+// missing header the same as an explicit "identity".
+func encodingLabel(enc string) string {
+	if enc == "" {
+		return "identity"
+	}
+	return enc
+}
+
 // ingest is a handler that accepts a batch of compressed data points.
 // Each batch is then pushed to the IncomingQueue for downstream destination writing.
 func ingest(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerConfig) {
 
-	// Validate key on every batch.
-	// May or may not be a good idea.
-	apiKey := req.Header.Get(httpConfig.APIKeyHeaderName)
-
 	var client string
 	xff := req.Header.Get("x-forwarded-for")
 	if xff != "" {
@@ -166,21 +219,46 @@ func ingest(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerCo
 		client = req.RemoteAddr
 	}
 
-	// Check if the api key that the request came with is valid.
-	_, valid := httpConfig.APIConfig[apiKey]
-	if !valid {
-		log.Infof("[client %s, api-key: %s] Not a valid api key\n",
-			client, apiKey)
+	// Resolve which customer this batch belongs to, either from the
+	// configured API key header or, in oidc mode, from a validated bearer
+	// token's claim.
+	var apiKey string
+	if httpConfig.AuthMode == "oidc" {
+		var ok bool
+		apiKey, ok = resolveOIDCCustomer(req, httpConfig)
+		if !ok {
+			log.Infof("[client %s] Not a valid bearer token\n", client)
+			req.Close = true
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	} else {
+		apiKey = req.Header.Get(httpConfig.APIKeyHeaderName)
+		if _, valid := httpConfig.APIConfig[apiKey]; !valid {
+			log.Infof("[client %s, api-key: %s] Not a valid api key\n",
+				client, apiKey)
+			req.Close = true
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Resolve the batch's Content-Encoding against the registered decoders
+	// and the customer's allow-list. "" is normalized to "identity".
+	encoding := encodingLabel(strings.ToLower(req.Header.Get("Content-Encoding")))
+	if _, ok := backends.Decoders[encoding]; !ok {
+		log.Infof("[client %s, api-key: %s] Unsupported content-encoding %q. Closing connection", client, config.Mask(apiKey, 4), encoding)
 		req.Close = true
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w, "unsupported Content-Encoding %q: supported encodings are gzip, snappy, zstd, identity\n", encoding)
 		return
 	}
-
-	// Accept only gzip compressed metrics
-	if req.Header.Get("Content-Encoding") != "gzip" {
-		log.Info("Gzip encoding header is not set. Closing connection")
+	cust := httpConfig.APIConfig[apiKey]
+	if !cust.EncodingAllowed(encoding) {
+		log.Infof("[client %s, api-key: %s] Content-Encoding %q is not permitted for this customer. Closing connection", client, config.Mask(apiKey, 4), encoding)
 		req.Close = true
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		fmt.Fprintf(w, "Content-Encoding %q is not permitted for this customer\n", encoding)
 		return
 	}
 
@@ -191,8 +269,13 @@ func ingest(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerCo
 	} else {
 		messageID = ""
 	}
+	custName := cust.Name
+
 	// counter metric by api key
-	go httpConfig.Statsd.SendStatsdCounterMetric(fmt.Sprintf("influx_router.%s.hits", strings.Replace(httpConfig.APIConfig[apiKey].Name, "-", "_", -1)), 1)
+	go httpConfig.Statsd.SendStatsdCounterMetric(fmt.Sprintf("influx_router.%s.hits", strings.Replace(custName, "-", "_", -1)), 1)
+	if httpConfig.Statsd.Prom != nil {
+		httpConfig.Statsd.Prom.Hits.WithLabelValues(custName, string(cust.Version())).Inc()
+	}
 
 	buf, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -201,9 +284,29 @@ func ingest(w http.ResponseWriter, req *http.Request, httpConfig *HTTPListenerCo
 	}
 
 	// batch (compressed) size counter metric by api key
-	go httpConfig.Statsd.SendStatsdCounterMetric(fmt.Sprintf("influx_router.%s.batch-size-bytes", strings.Replace(httpConfig.APIConfig[apiKey].Name, "-", "_", -1)), len(buf))
+	go httpConfig.Statsd.SendStatsdCounterMetric(fmt.Sprintf("influx_router.%s.batch-size-bytes", strings.Replace(custName, "-", "_", -1)), len(buf))
+	if httpConfig.Statsd.Prom != nil {
+		httpConfig.Statsd.Prom.BatchSizeBytes.WithLabelValues(custName, string(cust.Version())).Add(float64(len(buf)))
+	}
+
+	maxDecoded := httpConfig.MaxDecodedBatchBytes
+	if maxDecoded == 0 {
+		maxDecoded = defaultMaxDecodedBatchBytes
+	}
+	if _, err := backends.DecodeLimited(encoding, buf, maxDecoded); err != nil {
+		log.Errorf("[client %s, api-key: %s] Error decoding %s encoded body: %v", client, config.Mask(apiKey, 4), encoding, err)
+		req.Close = true
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// encoding adoption counter metric by api key
+	go httpConfig.Statsd.SendStatsdCounterMetric(fmt.Sprintf("influx_router.%s.encoding.%s", strings.Replace(custName, "-", "_", -1), encoding), 1)
+	if httpConfig.Statsd.Prom != nil {
+		httpConfig.Statsd.Prom.Encoding.WithLabelValues(custName, string(cust.Version()), encoding).Inc()
+	}
 
-	p := backends.Payload{MessageID: messageID, Body: buf, APIKey: apiKey}
+	p := backends.Payload{MessageID: messageID, Body: buf, APIKey: apiKey, Encoding: encoding}
 	select {
 	case httpConfig.IncomingQueue <- &p: // Put the batch into the channel unless it is full
 		w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,110 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+// TestIngestOIDCModeEndToEnd exercises ingest() under auth-mode=oidc with a
+// validly-signed JWT, covering the resolveOIDCCustomer -> APIConfig lookup
+// path that NewAPIKeyMap's oidc api_key requirement exists to keep working.
+func TestIngestOIDCModeEndToEnd(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestJWKSServer(t, key, "key1")
+	defer srv.Close()
+
+	validator, err := newOIDCValidator(srv.URL, "influx-router", "sub", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customers := []config.Customers{{Name: "acme", APIKey: "placeholder"}}
+	apiConf, err := config.NewAPIKeyMap(customers, false, "oidc")
+	if err != nil {
+		t.Fatalf("unexpected error building api key map: %v", err)
+	}
+
+	httpConfig := &HTTPListenerConfig{
+		AuthMode:      "oidc",
+		APIConfig:     apiConf,
+		IncomingQueue: make(chan *backends.Payload, 1),
+		Statsd:        &stats.Statsd{},
+		oidc:          validator,
+	}
+
+	token := signTestJWT(t, key, "key1", map[string]interface{}{
+		"iss": srv.URL,
+		"aud": "influx-router",
+		"sub": "acme",
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/write", bytes.NewReader([]byte("cpu,host=a value=1")))
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+
+	ingest(w, req, httpConfig)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want 204", w.Code)
+	}
+
+	select {
+	case p := <-httpConfig.IncomingQueue:
+		if p.APIKey != "placeholder" {
+			t.Errorf("got payload api key %q, want %q", p.APIKey, "placeholder")
+		}
+	default:
+		t.Fatal("expected a payload to be queued")
+	}
+}
+
+func TestIngestOIDCModeRejectsInvalidToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestJWKSServer(t, key, "key1")
+	defer srv.Close()
+
+	validator, err := newOIDCValidator(srv.URL, "influx-router", "sub", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customers := []config.Customers{{Name: "acme", APIKey: "placeholder"}}
+	apiConf, err := config.NewAPIKeyMap(customers, false, "oidc")
+	if err != nil {
+		t.Fatalf("unexpected error building api key map: %v", err)
+	}
+
+	httpConfig := &HTTPListenerConfig{
+		AuthMode:      "oidc",
+		APIConfig:     apiConf,
+		IncomingQueue: make(chan *backends.Payload, 1),
+		Statsd:        &stats.Statsd{},
+		oidc:          validator,
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/write", bytes.NewReader([]byte("cpu,host=a value=1")))
+	req.Header.Set("Authorization", "Bearer not-a-real-token")
+	w := httptest.NewRecorder()
+
+	ingest(w, req, httpConfig)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want 401", w.Code)
+	}
+}
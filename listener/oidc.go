@@ -0,0 +1,321 @@
+// Package listener provides code for managing incoming http requests.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package listener
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcDiscovery mirrors the subset of an OIDC provider's
+// .well-known/openid-configuration document the validator needs.
+type oidcDiscovery struct {
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// jwk is a single RSA key as published in a JWKS document.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDoc struct {
+	Keys []jwk `json:"keys"`
+}
+
+// oidcCacheEntry is a verified token's resolved claim, cached briefly so a
+// customer's batches don't pay for signature verification on every request.
+type oidcCacheEntry struct {
+	claim     string
+	expiresAt time.Time
+}
+
+// oidcCacheTTL bounds how long a verified token is cached, independent of
+// the token's own exp claim.
+const oidcCacheTTL = 30 * time.Second
+
+// oidcValidator authenticates Authorization: Bearer JWTs against an OIDC
+// issuer's JWKS, refreshed periodically in the background.
+type oidcValidator struct {
+	issuerURL string
+	audience  string
+	claimName string
+	client    *http.Client
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PublicKey
+
+	cacheMu sync.Mutex
+	cache   map[string]oidcCacheEntry
+}
+
+// newOIDCValidator discovers the issuer's JWKS endpoint, fetches the initial
+// key set and starts a background refresh loop at refreshInterval.
+func newOIDCValidator(issuerURL, audience, claimName string, refreshInterval time.Duration) (*oidcValidator, error) {
+	if claimName == "" {
+		claimName = "sub"
+	}
+
+	v := &oidcValidator{
+		issuerURL: issuerURL,
+		audience:  audience,
+		claimName: claimName,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		keys:      make(map[string]*rsa.PublicKey),
+		cache:     make(map[string]oidcCacheEntry),
+	}
+
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := v.refresh(); err != nil {
+				log.Errorf("error refreshing oidc jwks: %v", err)
+			}
+		}
+	}()
+
+	return v, nil
+}
+
+// refresh re-fetches the issuer's discovery document and JWKS.
+func (v *oidcValidator) refresh() error {
+	discURL := strings.TrimRight(v.issuerURL, "/") + "/.well-known/openid-configuration"
+	resp, err := v.client.Get(discURL)
+	if err != nil {
+		return fmt.Errorf("error fetching oidc discovery document: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var disc oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&disc); err != nil {
+		return fmt.Errorf("error decoding oidc discovery document: %v", err)
+	}
+
+	jwksResp, err := v.client.Get(disc.JWKSURI)
+	if err != nil {
+		return fmt.Errorf("error fetching jwks: %v", err)
+	}
+	defer jwksResp.Body.Close()
+
+	var doc jwksDoc
+	if err := json.NewDecoder(jwksResp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("error decoding jwks: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := rsaPublicKeyFromJWK(k)
+		if err != nil {
+			log.Errorf("error parsing jwk kid=%s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+	return nil
+}
+
+func rsaPublicKeyFromJWK(k jwk) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %v", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %v", err)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
+
+// validate verifies tokenString's signature and standard claims, and
+// returns the value of the configured claim (the resolved customer
+// identifier) on success.
+func (v *oidcValidator) validate(tokenString string) (string, error) {
+	v.cacheMu.Lock()
+	if entry, ok := v.cache[tokenString]; ok {
+		v.cacheMu.Unlock()
+		if time.Now().Before(entry.expiresAt) {
+			return entry.claim, nil
+		}
+	} else {
+		v.cacheMu.Unlock()
+	}
+
+	claim, exp, err := v.verify(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	cacheUntil := time.Now().Add(oidcCacheTTL)
+	if exp.Before(cacheUntil) {
+		cacheUntil = exp
+	}
+	v.cacheMu.Lock()
+	v.cache[tokenString] = oidcCacheEntry{claim: claim, expiresAt: cacheUntil}
+	v.cacheMu.Unlock()
+
+	return claim, nil
+}
+
+// verify checks the JWT's RS256 signature and iss/aud/exp claims, returning
+// the configured claim's value and the token's expiry.
+func (v *oidcValidator) verify(tokenString string) (string, time.Time, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return "", time.Time{}, errors.New("malformed jwt")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid jwt header encoding: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid jwt header: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return "", time.Time{}, fmt.Errorf("unsupported jwt alg: %s", header.Alg)
+	}
+
+	v.mu.RLock()
+	key, ok := v.keys[header.Kid]
+	v.mu.RUnlock()
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("no matching jwks key for kid: %s", header.Kid)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid jwt signature encoding: %v", err)
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return "", time.Time{}, fmt.Errorf("jwt signature verification failed: %v", err)
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid jwt payload encoding: %v", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payloadJSON, &claims); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid jwt payload: %v", err)
+	}
+
+	iss, _ := claims["iss"].(string)
+	if iss != v.issuerURL {
+		return "", time.Time{}, fmt.Errorf("unexpected issuer: %s", iss)
+	}
+
+	if !audienceMatches(claims["aud"], v.audience) {
+		return "", time.Time{}, fmt.Errorf("audience mismatch")
+	}
+
+	expFloat, ok := claims["exp"].(float64)
+	if !ok {
+		return "", time.Time{}, errors.New("missing exp claim")
+	}
+	exp := time.Unix(int64(expFloat), 0)
+	if time.Now().After(exp) {
+		return "", time.Time{}, errors.New("token expired")
+	}
+
+	claim, ok := claims[v.claimName].(string)
+	if !ok || claim == "" {
+		return "", time.Time{}, fmt.Errorf("missing or empty claim: %s", v.claimName)
+	}
+
+	return claim, exp, nil
+}
+
+// resolveOIDCCustomer validates req's Authorization: Bearer JWT and looks up
+// the config.Customers entry whose Name matches the resolved claim. It
+// returns that customer's APIKey (so the rest of ingest can keep treating
+// api key as the customer identifier) and whether resolution succeeded.
+func resolveOIDCCustomer(req *http.Request, httpConfig *HTTPListenerConfig) (string, bool) {
+	authz := req.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(authz, prefix) {
+		return "", false
+	}
+	token := strings.TrimPrefix(authz, prefix)
+
+	claim, err := httpConfig.oidc.validate(token)
+	if err != nil {
+		log.Infof("oidc token validation failed: %v", err)
+		return "", false
+	}
+
+	for apiKey, cust := range httpConfig.APIConfig {
+		if cust.Name == claim {
+			return apiKey, true
+		}
+	}
+	return "", false
+}
+
+// audienceMatches reports whether want appears in the JWT's aud claim,
+// which per spec may be either a single string or an array of strings.
+func audienceMatches(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package listener
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestJWKSServer serves a discovery document and JWKS for key, keyed by kid.
+func newTestJWKSServer(t *testing.T, key *rsa.PrivateKey, kid string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, `{"jwks_uri": "%s/jwks"}`, "http://"+req.Host)
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		n := base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes())
+		e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes())
+		fmt.Fprintf(w, `{"keys": [{"kty": "RSA", "kid": "%s", "alg": "RS256", "n": "%s", "e": "%s"}]}`, kid, n, e)
+	})
+	return httptest.NewServer(mux)
+}
+
+// signTestJWT builds and signs a minimal RS256 JWT with the given claims.
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCValidatorAcceptsValidToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestJWKSServer(t, key, "key1")
+	defer srv.Close()
+
+	v, err := newOIDCValidator(srv.URL, "influx-router", "sub", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signTestJWT(t, key, "key1", map[string]interface{}{
+		"iss": srv.URL,
+		"aud": "influx-router",
+		"sub": "acme",
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	claim, err := v.validate(token)
+	if err != nil {
+		t.Fatalf("expected valid token, got error: %v", err)
+	}
+	if claim != "acme" {
+		t.Errorf("got claim %q, want %q", claim, "acme")
+	}
+}
+
+func TestOIDCValidatorRejectsExpiredToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestJWKSServer(t, key, "key1")
+	defer srv.Close()
+
+	v, err := newOIDCValidator(srv.URL, "influx-router", "sub", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signTestJWT(t, key, "key1", map[string]interface{}{
+		"iss": srv.URL,
+		"aud": "influx-router",
+		"sub": "acme",
+		"exp": float64(time.Now().Add(-time.Hour).Unix()),
+	})
+
+	if _, err := v.validate(token); err == nil {
+		t.Error("expected expired token to be rejected")
+	}
+}
+
+func TestOIDCValidatorRejectsWrongAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestJWKSServer(t, key, "key1")
+	defer srv.Close()
+
+	v, err := newOIDCValidator(srv.URL, "influx-router", "sub", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signTestJWT(t, key, "key1", map[string]interface{}{
+		"iss": srv.URL,
+		"aud": "someone-else",
+		"sub": "acme",
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})
+
+	if _, err := v.validate(token); err == nil {
+		t.Error("expected wrong-audience token to be rejected")
+	}
+}
@@ -0,0 +1,91 @@
+// Package logging provides a thin, prefixed wrapper around the standard
+// library logger so that log lines can be attributed to the package that
+// emitted them.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// Logger prefixes every line with the name of the component that owns it.
+type Logger struct {
+	prefix string
+	l      *log.Logger
+}
+
+// For returns a Logger scoped to the given component name.
+func For(name string) *Logger {
+	return &Logger{
+		prefix: name,
+		l:      log.New(os.Stderr, "", log.LstdFlags|log.LUTC),
+	}
+}
+
+func (lg *Logger) output(level, s string) {
+	lg.l.Printf("[%s] %s: %s", level, lg.prefix, s)
+}
+
+// Info logs an informational message.
+func (lg *Logger) Info(args ...interface{}) {
+	lg.output("INFO", fmt.Sprint(args...))
+}
+
+// Infof logs a formatted informational message.
+func (lg *Logger) Infof(format string, args ...interface{}) {
+	lg.output("INFO", fmt.Sprintf(format, args...))
+}
+
+// Print logs a message at the default level.
+func (lg *Logger) Print(args ...interface{}) {
+	lg.output("INFO", fmt.Sprint(args...))
+}
+
+// Printf logs a formatted message at the default level.
+func (lg *Logger) Printf(format string, args ...interface{}) {
+	lg.output("INFO", fmt.Sprintf(format, args...))
+}
+
+// Error logs an error message.
+func (lg *Logger) Error(args ...interface{}) {
+	lg.output("ERROR", fmt.Sprint(args...))
+}
+
+// Errorf logs a formatted error message.
+func (lg *Logger) Errorf(format string, args ...interface{}) {
+	lg.output("ERROR", fmt.Sprintf(format, args...))
+}
+
+// Fatal logs a message and then exits the process with a non-zero status.
+func (lg *Logger) Fatal(args ...interface{}) {
+	lg.output("FATAL", fmt.Sprint(args...))
+	os.Exit(1)
+}
+
+// Fatalf logs a formatted message and then exits the process with a non-zero status.
+func (lg *Logger) Fatalf(format string, args ...interface{}) {
+	lg.output("FATAL", fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
@@ -42,25 +42,29 @@ import (
 
 var (
 	options struct {
-		apiAddr            string
-		apiPort            string
-		authEnabled        bool
-		authMode           string
-		addr               string
-		httpPort           string
-		httpsPort          string
-		incomingQueuecap   int
-		secure             bool
-		sslServerCert      string
-		sslCAServerCert    string
-		sslServerKey       string
-		sslClientCertAuth  bool
-		configFile         string
-		apiKeyHeaderName   string
-		waitBeforeShutdown int
-		statsdServer       string
-		statsInterval      int
-		version            bool
+		apiAddr              string
+		apiPort              string
+		authEnabled          bool
+		authMode             string
+		addr                 string
+		httpPort             string
+		httpsPort            string
+		incomingQueuecap     int
+		secure               bool
+		sslServerCert        string
+		sslCAServerCert      string
+		sslServerKey         string
+		sslClientCertAuth    bool
+		configFile           string
+		apiKeyHeaderName     string
+		waitBeforeShutdown   int
+		statsdServer         string
+		statsInterval        int
+		version              bool
+		maxRequestsInFlight  int
+		longRunningRequestRE string
+		promEnabled          bool
+		maxDecodedBatchBytes int64
 	}
 
 	sigChan = make(chan os.Signal)
@@ -72,7 +76,7 @@ var (
 
 func init() {
 	flag.BoolVar(&options.authEnabled, "auth-enabled", false, "Whether to enable authentication when communicating with InfluxDB")
-	flag.StringVar(&options.authMode, "auth-mode", "from-config", "Can be either 'from-config or 'from-env' presently. 'auth-enabled' flag needs to be turned on.")
+	flag.StringVar(&options.authMode, "auth-mode", "from-config", "Can be 'from-config', 'from-env' or 'oidc'. 'from-config'/'from-env' need the 'auth-enabled' flag turned on. 'oidc' validates an Authorization: Bearer JWT against config.toml's [oidc] section instead of the api-key header; every customer still needs an api_key configured in this mode since it's used as the internal routing key.")
 	flag.StringVar(&options.addr, "listen-addr", "0.0.0.0", "InfluxDB router listen address")
 	flag.StringVar(&options.httpPort, "listen-http-port", "8090", "InfluxDB router listen port (http)")
 	flag.StringVar(&options.apiAddr, "api-listen-addr", "127.0.0.1", "InfluxDB router api listen address")
@@ -90,6 +94,10 @@ func init() {
 	flag.StringVar(&options.statsdServer, "statsd-server", "localhost:8125", "statsd server:port for sending metrics")
 	flag.IntVar(&options.statsInterval, "stats-interval", 30, "Interval in seconds for sending statsd metrics.")
 	flag.BoolVar(&options.version, "version", false, "version of the binary.")
+	flag.IntVar(&options.maxRequestsInFlight, "max-requests-in-flight", 0, "Maximum number of requests processed concurrently on the ingest listener. 0 disables the limit.")
+	flag.StringVar(&options.longRunningRequestRE, "long-running-request-re", "^GET /health$", "Regex matched against 'METHOD path' for requests that bypass the in-flight limit.")
+	flag.BoolVar(&options.promEnabled, "prom-enabled", false, "Whether to expose a /metrics endpoint on the api listener for prometheus scraping.")
+	flag.Int64Var(&options.maxDecodedBatchBytes, "max-decoded-batch-bytes", 64<<20, "Maximum number of bytes a batch may decompress to during ingest. Guards against decompression-bomb style Content-Encoding bodies. Unlike other 'max-*' flags here, 0 does not disable the cap: it falls back to the 64MiB default.")
 
 	envy.Parse("INFLUX")
 	flag.Parse()
@@ -148,9 +156,6 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Output writer.
-	go writer.OutQueueWriter(apiConf, incomingQueue, ready)
-
 	// start statsd metrics tracker
 	c, err := stats.ConnectStatsd(options.statsdServer, "udp")
 	if err != nil {
@@ -160,35 +165,51 @@ func main() {
 	sc := stats.Statsd{
 		Interval: options.statsInterval,
 		Conn:     c,
+		Prom:     stats.NewPrometheus(),
 	}
 	go stats.ExportMetrics(&sc, options.incomingQueuecap, incomingQueue, apiConf)
 
+	// Output writer.
+	retrier, err := writer.NewRetrier(conf, &sc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go writer.OutQueueWriter(apiConf, incomingQueue, retrier, ready)
+
 	// wait till the writer is ready.
 	<-ready
 
 	// HTTP Listener.
 	go listener.HTTPListener(&listener.HTTPListenerConfig{
-		Addr:              options.addr,
-		HTTPPort:          options.httpPort,
-		HTTPSPort:         options.httpsPort,
-		IncomingQueue:     incomingQueue,
-		Secure:            options.secure,
-		SSLCAServerCert:   options.sslCAServerCert,
-		SSLServerCert:     options.sslServerCert,
-		SSLServerKey:      options.sslServerKey,
-		SSLClientCertAuth: options.sslClientCertAuth,
-		APIConfig:         apiConf,
-		APIKeyHeaderName:  options.apiKeyHeaderName,
-		HealthCheck:       healthCheck,
-		Statsd:            &sc,
+		Addr:                 options.addr,
+		HTTPPort:             options.httpPort,
+		HTTPSPort:            options.httpsPort,
+		IncomingQueue:        incomingQueue,
+		Secure:               options.secure,
+		SSLCAServerCert:      options.sslCAServerCert,
+		SSLServerCert:        options.sslServerCert,
+		SSLServerKey:         options.sslServerKey,
+		SSLClientCertAuth:    options.sslClientCertAuth,
+		APIConfig:            apiConf,
+		APIKeyHeaderName:     options.apiKeyHeaderName,
+		HealthCheck:          healthCheck,
+		Statsd:               &sc,
+		MaxRequestsInFlight:  options.maxRequestsInFlight,
+		LongRunningRequestRE: options.longRunningRequestRE,
+		AuthMode:             options.authMode,
+		OIDC:                 conf.OIDC,
+		MaxDecodedBatchBytes: options.maxDecodedBatchBytes,
 	})
 
 	// API listener.
 	go api.HTTPListener(&api.HTTPListenerConfig{
-		Addr:     options.apiAddr,
-		Port:     options.apiPort,
-		TomlConf: *conf,
-		APIConf:  apiConf,
+		Addr:         options.apiAddr,
+		Port:         options.apiPort,
+		TomlConf:     *conf,
+		APIConf:      apiConf,
+		PromEnabled:  options.promEnabled,
+		PromGatherer: sc.Prom.Registry,
+		Prom:         sc.Prom,
 	})
 
 	handleSignals(healthCheck)
@@ -0,0 +1,120 @@
+// influxdb-router-replay reads dead-lettered batches written by the router's
+// retry subsystem and re-POSTs them to a running router's /write endpoint.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/samitpal/influxdb-router/backends"
+)
+
+var options struct {
+	dlqDir     string
+	routerAddr string
+	apiKeyName string
+	purge      bool
+}
+
+func init() {
+	flag.StringVar(&options.dlqDir, "dlq-dir", "", "Path to a customer's dead-letter directory, e.g. /var/influxdb-router/dlq/acme")
+	flag.StringVar(&options.routerAddr, "router-addr", "http://127.0.0.1:8090", "Base address of the influxdb-router to replay batches to")
+	flag.StringVar(&options.apiKeyName, "api-key-header-name", "Service-API-Key", "Name of the API key header expected by the router")
+	flag.BoolVar(&options.purge, "purge", false, "Remove each dlq file after it is successfully replayed")
+	flag.Parse()
+}
+
+func main() {
+	if options.dlqDir == "" {
+		fmt.Fprintln(os.Stderr, "error: -dlq-dir is required")
+		os.Exit(1)
+	}
+
+	entries, err := os.ReadDir(options.dlqDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading dlq dir %s: %v\n", options.dlqDir, err)
+		os.Exit(1)
+	}
+
+	var failed int
+	for _, e := range entries {
+		path := filepath.Join(options.dlqDir, e.Name())
+		if err := replay(path); err != nil {
+			fmt.Fprintf(os.Stderr, "error replaying %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("replayed %s\n", path)
+		if options.purge {
+			if err := os.Remove(path); err != nil {
+				fmt.Fprintf(os.Stderr, "error removing %s: %v\n", path, err)
+			}
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// replay reads a single dlq file and re-posts its body to the router.
+func replay(path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var rec backends.DLQRecord
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		return fmt.Errorf("decoding dlq record: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, options.routerAddr+"/write", bytes.NewReader(rec.Body))
+	if err != nil {
+		return err
+	}
+	encoding := rec.Encoding
+	if encoding == "" {
+		// Records dead-lettered before encoding was tracked are always gzip.
+		encoding = "gzip"
+	}
+	req.Header.Set("Content-Encoding", encoding)
+	req.Header.Set(options.apiKeyName, rec.APIKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("router returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+// Package stats emits operational metrics about the router, primarily via
+// statsd.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package stats
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Prometheus mirrors the counters and gauges the router already sends to
+// statsd as native prometheus collectors, labelled by customer and (where
+// relevant) backend version, so operators can scrape instead of or
+// alongside pushing to statsd.
+type Prometheus struct {
+	Registry *prometheus.Registry
+
+	Hits           *prometheus.CounterVec
+	BatchSizeBytes *prometheus.CounterVec
+	QueueDepth     prometheus.Gauge
+	InFlight       prometheus.Gauge
+	BackendStatus  *prometheus.CounterVec
+	RetryAttempts  *prometheus.CounterVec
+	DLQSize        *prometheus.GaugeVec
+	Encoding       *prometheus.CounterVec
+}
+
+// NewPrometheus builds a Prometheus with all collectors registered against
+// a fresh registry.
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		Registry: prometheus.NewRegistry(),
+		Hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "influx_router_hits_total",
+			Help: "Number of authenticated write requests with a permitted Content-Encoding, by customer and backend version.",
+		}, []string{"customer", "backend"}),
+		BatchSizeBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "influx_router_batch_size_bytes_total",
+			Help: "Total compressed batch bytes received, by customer and backend version.",
+		}, []string{"customer", "backend"}),
+		QueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "influx_router_queue_depth",
+			Help: "Current depth of the incoming queue.",
+		}),
+		InFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "influx_router_inflight_requests",
+			Help: "Current number of in-flight ingest requests.",
+		}),
+		BackendStatus: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "influx_router_backend_status_total",
+			Help: "InfluxDB backend response counts, by customer, backend version and status.",
+		}, []string{"customer", "backend", "status"}),
+		RetryAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "influx_router_retry_attempts_total",
+			Help: "Write retry attempts, by customer and backend version.",
+		}, []string{"customer", "backend"}),
+		DLQSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "influx_router_dlq_size",
+			Help: "Number of batches dead-lettered since start or since the customer's dlq was last purged, by customer and backend version.",
+		}, []string{"customer", "backend"}),
+		Encoding: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "influx_router_encoding_total",
+			Help: "Accepted write requests, by customer, backend version and Content-Encoding.",
+		}, []string{"customer", "backend", "encoding"}),
+	}
+
+	p.Registry.MustRegister(
+		p.Hits,
+		p.BatchSizeBytes,
+		p.QueueDepth,
+		p.InFlight,
+		p.BackendStatus,
+		p.RetryAttempts,
+		p.DLQSize,
+		p.Encoding,
+	)
+
+	return p
+}
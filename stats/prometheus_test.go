@@ -0,0 +1,23 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusAlongsideNilStatsdConn(t *testing.T) {
+	p := NewPrometheus()
+	s := &Statsd{Prom: p}
+
+	p.Hits.WithLabelValues("acme", "v1").Inc()
+	if got := testutil.ToFloat64(p.Hits.WithLabelValues("acme", "v1")); got != 1 {
+		t.Errorf("got Hits=%v, want 1", got)
+	}
+
+	// SendStatsdCounterMetric/SendStatsdGaugeMetric must not panic when Conn
+	// is nil, even with Prom set, since a metric helper might be called
+	// before the statsd connection is established.
+	s.SendStatsdCounterMetric("influx_router.acme.hits", 1)
+	s.SendStatsdGaugeMetric("influx_router.queue.depth", 1)
+}
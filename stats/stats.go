@@ -0,0 +1,110 @@
+// Package stats emits operational metrics about the router, primarily via
+// statsd.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package stats
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/logging"
+)
+
+var log = logging.For("stats")
+
+// Statsd wraps a UDP connection to a statsd server along with the interval
+// at which queue depth metrics are exported. Prom is optional; when set,
+// callers update the matching prometheus collector next to their Send*
+// call, so operators can migrate from statsd to prometheus incrementally.
+// The Send* methods themselves only write to statsd.
+type Statsd struct {
+	Interval int
+	Conn     net.Conn
+	Prom     *Prometheus
+}
+
+// ConnectStatsd dials the given statsd server over the given network
+// ("udp" in production).
+func ConnectStatsd(addr, network string) (net.Conn, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing statsd server %s: %v", addr, err)
+	}
+	return conn, nil
+}
+
+// SendStatsdCounterMetric sends a counter increment of value for the given
+// metric name. It is a no-op if the statsd connection was never established.
+func (s *Statsd) SendStatsdCounterMetric(name string, value int) {
+	if s.Conn == nil {
+		return
+	}
+	msg := fmt.Sprintf("%s:%d|c", name, value)
+	if _, err := s.Conn.Write([]byte(msg)); err != nil {
+		log.Errorf("error writing statsd metric %s: %v", name, err)
+	}
+}
+
+// SendStatsdGaugeMetric sends a gauge reading of value for the given metric
+// name. Unlike a counter, a gauge reports the current value of something
+// (e.g. a queue depth) rather than an increment.
+func (s *Statsd) SendStatsdGaugeMetric(name string, value int) {
+	if s.Conn == nil {
+		return
+	}
+	msg := fmt.Sprintf("%s:%d|g", name, value)
+	if _, err := s.Conn.Write([]byte(msg)); err != nil {
+		log.Errorf("error writing statsd metric %s: %v", name, err)
+	}
+}
+
+// sanitize makes a customer name safe to use as a statsd metric path segment.
+func sanitize(name string) string {
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// ExportMetrics periodically reports the depth of incomingQueue, tagging
+// each customer's counters with their configured backend version so
+// operators can tell v1 and v2 traffic apart.
+func ExportMetrics(s *Statsd, queueCap int, incomingQueue chan *backends.Payload, apiConf config.APIKeyMap) {
+	ticker := time.NewTicker(time.Duration(s.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		depth := len(incomingQueue)
+		s.SendStatsdGaugeMetric("influx_router.queue.depth", depth)
+		s.SendStatsdCounterMetric("influx_router.queue.capacity", queueCap)
+		if s.Prom != nil {
+			s.Prom.QueueDepth.Set(float64(depth))
+		}
+
+		for _, cust := range apiConf {
+			metric := fmt.Sprintf("influx_router.%s.backend.%s.heartbeat", sanitize(cust.Name), cust.Version())
+			s.SendStatsdCounterMetric(metric, 1)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package writer drains the incoming queue and delivers each batch to the
+// customer's configured InfluxDB backend.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package writer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+// Defaults used when the corresponding config.toml knob is left unset.
+const (
+	defaultRetryInitial    = 500 * time.Millisecond
+	defaultRetryMax        = 30 * time.Second
+	defaultRetryMultiplier = 2.0
+	defaultMaxAttempts     = 5
+)
+
+// Retrier schedules backoff retries for failed writes and, once a batch
+// exhausts its attempts, persists it to DLQDir for later replay via the
+// influxdb-router-replay command.
+type Retrier struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	MaxAttempts int
+	DLQDir      string
+	Statsd      *stats.Statsd
+
+	depth int32
+}
+
+// NewRetrier builds a Retrier from the retry_* and dlq_dir knobs in conf,
+// falling back to sane defaults for anything left unset.
+func NewRetrier(conf *config.Conf, sd *stats.Statsd) (*Retrier, error) {
+	r := &Retrier{
+		Initial:     defaultRetryInitial,
+		Max:         defaultRetryMax,
+		Multiplier:  defaultRetryMultiplier,
+		MaxAttempts: defaultMaxAttempts,
+		DLQDir:      conf.DLQDir,
+		Statsd:      sd,
+	}
+
+	if conf.RetryInitial != "" {
+		d, err := time.ParseDuration(conf.RetryInitial)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_initial %q: %v", conf.RetryInitial, err)
+		}
+		r.Initial = d
+	}
+	if conf.RetryMax != "" {
+		d, err := time.ParseDuration(conf.RetryMax)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry_max %q: %v", conf.RetryMax, err)
+		}
+		r.Max = d
+	}
+	if conf.RetryMultiplier != 0 {
+		r.Multiplier = conf.RetryMultiplier
+	}
+	if conf.MaxAttempts != 0 {
+		r.MaxAttempts = conf.MaxAttempts
+	}
+
+	return r, nil
+}
+
+// backoff returns the delay before the given retry attempt (1-indexed).
+func (r *Retrier) backoff(attempt int) time.Duration {
+	d := float64(r.Initial) * math.Pow(r.Multiplier, float64(attempt-1))
+	if d > float64(r.Max) {
+		d = float64(r.Max)
+	}
+	return time.Duration(d)
+}
+
+// Schedule retries p against cust after an exponential backoff. Once attempt
+// exceeds MaxAttempts, p is dead-lettered instead of retried again.
+func (r *Retrier) Schedule(client *http.Client, cust config.Customers, p *backends.Payload, attempt int) {
+	if attempt > r.MaxAttempts {
+		r.deadLetter(cust, p, attempt-1)
+		return
+	}
+
+	d := r.backoff(attempt)
+	depth := atomic.AddInt32(&r.depth, 1)
+	r.Statsd.SendStatsdGaugeMetric("influx_router.retry.depth", int(depth))
+	go r.Statsd.SendStatsdCounterMetric("influx_router.retry.attempts", 1)
+	if r.Statsd.Prom != nil {
+		r.Statsd.Prom.RetryAttempts.WithLabelValues(cust.Name, string(cust.Version())).Inc()
+	}
+
+	time.AfterFunc(d, func() {
+		depth := atomic.AddInt32(&r.depth, -1)
+		r.Statsd.SendStatsdGaugeMetric("influx_router.retry.depth", int(depth))
+
+		resp, err := write(client, cust, p)
+		if err != nil {
+			log.Errorf("message-id: %s, customer: %s, retry %d failed: %v", p.MessageID, cust.Name, attempt, err)
+			r.Schedule(client, cust, p, attempt+1)
+			return
+		}
+		defer resp.Body.Close()
+		recordBackendStatus(r.Statsd, cust, resp.StatusCode)
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			log.Errorf("message-id: %s, customer: %s, retry %d failed with status %d", p.MessageID, cust.Name, attempt, resp.StatusCode)
+			r.Schedule(client, cust, p, attempt+1)
+		}
+	})
+}
+
+// deadLetter persists p to DLQDir/<customer>/<MessageID>.json so it can be
+// inspected or replayed later. If DLQDir isn't configured, the batch is
+// logged and dropped.
+func (r *Retrier) deadLetter(cust config.Customers, p *backends.Payload, attempts int) {
+	if r.DLQDir == "" {
+		log.Errorf("message-id: %s, customer: %s, exhausted %d retry attempts, no dlq_dir configured, dropping batch", p.MessageID, cust.Name, attempts)
+		return
+	}
+
+	dir := filepath.Join(r.DLQDir, cust.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Errorf("message-id: %s, customer: %s, error creating dlq dir %s: %v", p.MessageID, cust.Name, dir, err)
+		return
+	}
+
+	rec := backends.DLQRecord{MessageID: p.MessageID, Customer: cust.Name, APIKey: p.APIKey, Body: p.Body, Encoding: p.Encoding, Attempts: attempts}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		log.Errorf("message-id: %s, customer: %s, error marshaling dlq record: %v", p.MessageID, cust.Name, err)
+		return
+	}
+
+	path := filepath.Join(dir, p.MessageID+".json")
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		log.Errorf("message-id: %s, customer: %s, error writing dlq file %s: %v", p.MessageID, cust.Name, path, err)
+		return
+	}
+
+	log.Infof("message-id: %s, customer: %s, dead-lettered after %d attempts to %s", p.MessageID, cust.Name, attempts, path)
+	metric := fmt.Sprintf("influx_router.%s.dlq.count", strings.Replace(cust.Name, "-", "_", -1))
+	go r.Statsd.SendStatsdCounterMetric(metric, 1)
+	if r.Statsd.Prom != nil {
+		r.Statsd.Prom.DLQSize.WithLabelValues(cust.Name, string(cust.Version())).Inc()
+	}
+}
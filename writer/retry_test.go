@@ -0,0 +1,75 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+func TestRetrierBackoff(t *testing.T) {
+	r := &Retrier{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second}, // clamped to Max
+	}
+	for _, tc := range cases {
+		if got := r.backoff(tc.attempt); got != tc.want {
+			t.Errorf("backoff(%d) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestRetrierDeadLettersAfterMaxAttempts(t *testing.T) {
+	dir := t.TempDir()
+	r := &Retrier{
+		Initial: time.Millisecond, Max: time.Millisecond, Multiplier: 1,
+		MaxAttempts: 1, DLQDir: dir, Statsd: &stats.Statsd{},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	cust := config.Customers{Name: "acme", URL: srv.URL, DB: "metrics"}
+	p := &backends.Payload{MessageID: "msg-1", APIKey: "key1", Body: []byte("cpu value=1")}
+
+	r.Schedule(srv.Client(), cust, p, 1)
+
+	// Schedule uses time.AfterFunc; give it a moment to fire and dead-letter.
+	deadline := time.Now().Add(2 * time.Second)
+	path := filepath.Join(dir, "acme", "msg-1.json")
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected dlq file at %s: %v", path, err)
+	}
+
+	var rec backends.DLQRecord
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		t.Fatalf("error decoding dlq file: %v", err)
+	}
+	if rec.MessageID != "msg-1" || rec.Customer != "acme" || rec.APIKey != "key1" {
+		t.Errorf("unexpected dlq record: %+v", rec)
+	}
+}
@@ -0,0 +1,250 @@
+// Package writer drains the incoming queue and delivers each batch to the
+// customer's configured InfluxDB backend.
+// The MIT License (MIT)
+//
+// Copyright (c) 2017 Samit Pal
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package writer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+	"github.com/samitpal/influxdb-router/logging"
+	"github.com/samitpal/influxdb-router/stats"
+)
+
+var log = logging.For("writer")
+
+// httpTimeout bounds a single write attempt to a backend.
+const httpTimeout = 30 * time.Second
+
+// newHTTPClient builds an http.Client with TLS verification and proxy
+// settings derived from the customer's config.
+func newHTTPClient(cust config.Customers) (*http.Client, error) {
+	transport := &http.Transport{}
+
+	if cust.SkipSSLCertVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if cust.ProxyURL != "" {
+		proxyURL, err := url.Parse(cust.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_url for customer %s: %v", cust.Name, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{Transport: transport, Timeout: httpTimeout}, nil
+}
+
+// clientCache lazily builds one http.Client per customer and reuses it
+// across writes and retries, so the keep-alive connection to a customer's
+// backend survives between batches instead of paying for a fresh TCP/TLS
+// handshake on every one.
+type clientCache struct {
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+func newClientCache() *clientCache {
+	return &clientCache{clients: make(map[string]*http.Client)}
+}
+
+// get returns the cached client for cust, building and caching one on first
+// use.
+func (c *clientCache) get(cust config.Customers) (*http.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[cust.Name]; ok {
+		return client, nil
+	}
+	client, err := newHTTPClient(cust)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[cust.Name] = client
+	return client, nil
+}
+
+// encodeForBackend returns the bytes to post to cust's backend along with
+// the Content-Encoding header value to send with them. A batch is passed
+// through as-is when the backend accepts its original encoding; otherwise
+// it is decompressed and re-encoded to gzip. An identity batch is always
+// sent uncompressed with no Content-Encoding header. An unset p.Encoding is
+// treated as "gzip" for compatibility with payloads built before Encoding
+// existed.
+func encodeForBackend(cust config.Customers, p *backends.Payload) ([]byte, string, error) {
+	enc := p.Encoding
+	if enc == "" {
+		enc = "gzip"
+	}
+	if enc == "identity" {
+		return p.Body, "", nil
+	}
+	if cust.BackendSupportsEncoding(enc) {
+		return p.Body, enc, nil
+	}
+
+	decoded, err := backends.Decode(enc, p.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error decoding %s payload for re-encoding: %v", enc, err)
+	}
+	gzipped, err := backends.GzipEncode(decoded)
+	if err != nil {
+		return nil, "", fmt.Errorf("error gzip re-encoding payload: %v", err)
+	}
+	return gzipped, "gzip", nil
+}
+
+// writeV1 posts a batch to a legacy InfluxDB 1.x /write endpoint.
+func writeV1(client *http.Client, cust config.Customers, p *backends.Payload) (*http.Response, error) {
+	u, err := url.Parse(cust.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url for customer %s: %v", cust.Name, err)
+	}
+	q := u.Query()
+	q.Set("db", cust.DB)
+	u.RawQuery = q.Encode()
+
+	body, encoding, err := encodeForBackend(cust, p)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	return client.Do(req)
+}
+
+// writeV2 posts a batch to an InfluxDB 2.x /api/v2/write endpoint using
+// token based auth.
+func writeV2(client *http.Client, cust config.Customers, p *backends.Payload) (*http.Response, error) {
+	u, err := url.Parse(cust.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url for customer %s: %v", cust.Name, err)
+	}
+	u.Path = "/api/v2/write"
+
+	precision := cust.Precision
+	if precision == "" {
+		precision = "ns"
+	}
+
+	q := u.Query()
+	q.Set("org", cust.Org)
+	q.Set("bucket", cust.Bucket)
+	q.Set("precision", precision)
+	u.RawQuery = q.Encode()
+
+	body, encoding, err := encodeForBackend(cust, p)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Set("Authorization", "Token "+cust.Token)
+	return client.Do(req)
+}
+
+// write dispatches a batch to the v1 or v2 backend based on the customer's
+// configured influx_version.
+func write(client *http.Client, cust config.Customers, p *backends.Payload) (*http.Response, error) {
+	switch cust.Version() {
+	case config.InfluxV2:
+		return writeV2(client, cust, p)
+	default:
+		return writeV1(client, cust, p)
+	}
+}
+
+// OutQueueWriter consumes payloads from incomingQueue and forwards each one
+// to its customer's configured InfluxDB backend, signalling on ready once it
+// has started. 5xx responses and network errors are handed off to retrier
+// for backoff retry and eventual dead-lettering.
+func OutQueueWriter(apiConf config.APIKeyMap, incomingQueue chan *backends.Payload, retrier *Retrier, ready chan bool) {
+	ready <- true
+	clients := newClientCache()
+
+	for p := range incomingQueue {
+		cust, ok := apiConf[p.APIKey]
+		if !ok {
+			log.Errorf("message-id: %s, dropping payload for unknown api key", p.MessageID)
+			continue
+		}
+
+		client, err := clients.get(cust)
+		if err != nil {
+			log.Errorf("message-id: %s, customer: %s, %v", p.MessageID, cust.Name, err)
+			continue
+		}
+
+		resp, err := write(client, cust, p)
+		if err != nil {
+			log.Errorf("message-id: %s, customer: %s, backend: %s, error writing to influxdb: %v", p.MessageID, cust.Name, cust.Version(), err)
+			retrier.Schedule(client, cust, p, 1)
+			continue
+		}
+		resp.Body.Close()
+		recordBackendStatus(retrier.Statsd, cust, resp.StatusCode)
+
+		switch {
+		case resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK:
+			// Success.
+		case resp.StatusCode == http.StatusBadRequest:
+			log.Errorf("message-id: %s, customer: %s, backend: %s, influxdb rejected batch: 400 Bad Request", p.MessageID, cust.Name, cust.Version())
+		case resp.StatusCode == http.StatusUnauthorized:
+			log.Errorf("message-id: %s, customer: %s, backend: %s, influxdb rejected batch: 401 Unauthorized", p.MessageID, cust.Name, cust.Version())
+		case resp.StatusCode >= http.StatusInternalServerError:
+			log.Errorf("message-id: %s, customer: %s, backend: %s, influxdb returned status: %d, scheduling retry", p.MessageID, cust.Name, cust.Version(), resp.StatusCode)
+			retrier.Schedule(client, cust, p, 1)
+		default:
+			log.Errorf("message-id: %s, customer: %s, backend: %s, influxdb returned unexpected status: %d", p.MessageID, cust.Name, cust.Version(), resp.StatusCode)
+		}
+	}
+}
+
+// recordBackendStatus mirrors an InfluxDB backend response status to
+// prometheus, labelled by customer and backend version.
+func recordBackendStatus(sd *stats.Statsd, cust config.Customers, status int) {
+	if sd == nil || sd.Prom == nil {
+		return
+	}
+	sd.Prom.BackendStatus.WithLabelValues(cust.Name, string(cust.Version()), strconv.Itoa(status)).Inc()
+}
@@ -0,0 +1,217 @@
+package writer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/samitpal/influxdb-router/backends"
+	"github.com/samitpal/influxdb-router/config"
+)
+
+func testPayload() *backends.Payload {
+	return &backends.Payload{MessageID: "abc123", Body: []byte("cpu,host=a value=1"), APIKey: "key1"}
+}
+
+func TestWriteV1(t *testing.T) {
+	var gotPath, gotDB string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotDB = r.URL.Query().Get("db")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cust := config.Customers{Name: "acme", URL: srv.URL, DB: "metrics"}
+	client := srv.Client()
+
+	resp, err := write(client, cust, testPayload())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("got status %d, want 204", resp.StatusCode)
+	}
+	if gotPath != "" && gotPath != "/" {
+		t.Errorf("unexpected path for v1 write: %s", gotPath)
+	}
+	if gotDB != "metrics" {
+		t.Errorf("got db=%q, want metrics", gotDB)
+	}
+}
+
+func TestWriteV2(t *testing.T) {
+	var gotPath string
+	var gotQuery url.Values
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.Query()
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cust := config.Customers{
+		Name:          "acme-v2",
+		URL:           srv.URL,
+		InfluxVersion: config.InfluxV2,
+		Token:         "my-token",
+		Org:           "my-org",
+		Bucket:        "my-bucket",
+	}
+	client := srv.Client()
+
+	resp, err := write(client, cust, testPayload())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if gotPath != "/api/v2/write" {
+		t.Errorf("got path %q, want /api/v2/write", gotPath)
+	}
+	if gotQuery.Get("org") != "my-org" || gotQuery.Get("bucket") != "my-bucket" || gotQuery.Get("precision") != "ns" {
+		t.Errorf("unexpected query params: %v", gotQuery)
+	}
+	if gotAuth != "Token my-token" {
+		t.Errorf("got Authorization %q, want Token my-token", gotAuth)
+	}
+}
+
+func TestWriteV2CustomPrecision(t *testing.T) {
+	var gotQuery url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cust := config.Customers{
+		Name: "acme-v2", URL: srv.URL, InfluxVersion: config.InfluxV2,
+		Token: "t", Org: "o", Bucket: "b", Precision: "s",
+	}
+	resp, err := write(srv.Client(), cust, testPayload())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if gotQuery.Get("precision") != "s" {
+		t.Errorf("got precision %q, want s", gotQuery.Get("precision"))
+	}
+}
+
+func TestWriteResponseCodes(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+	}{
+		{"no-content", http.StatusNoContent},
+		{"bad-request", http.StatusBadRequest},
+		{"unauthorized", http.StatusUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			cust := config.Customers{Name: "acme", URL: srv.URL, DB: "metrics"}
+			resp, err := write(srv.Client(), cust, testPayload())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.status {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tc.status)
+			}
+		})
+	}
+}
+
+func TestClientCacheReusesClientPerCustomer(t *testing.T) {
+	c := newClientCache()
+
+	cust := config.Customers{Name: "acme"}
+	client1, err := c.get(cust)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client2, err := c.get(cust)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client1 != client2 {
+		t.Error("expected the same *http.Client for repeated calls with the same customer")
+	}
+
+	other, err := c.get(config.Customers{Name: "other"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other == client1 {
+		t.Error("expected a distinct *http.Client for a different customer")
+	}
+}
+
+func TestEncodeForBackendPassThrough(t *testing.T) {
+	cust := config.Customers{Name: "acme", BackendEncodings: []string{"snappy"}}
+	p := &backends.Payload{Body: []byte("snappy-body"), Encoding: "snappy"}
+
+	body, encoding, err := encodeForBackend(cust, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if encoding != "snappy" {
+		t.Errorf("got encoding %q, want snappy", encoding)
+	}
+	if string(body) != "snappy-body" {
+		t.Errorf("got body %q, want unchanged pass-through", body)
+	}
+}
+
+func TestEncodeForBackendReEncodesToGzip(t *testing.T) {
+	cust := config.Customers{Name: "acme"} // no backend_encodings -> gzip only
+	want := []byte("cpu,host=a value=1")
+	snappied := snappy.Encode(nil, want)
+	p := &backends.Payload{Body: snappied, Encoding: "snappy"}
+
+	body, encoding, err := encodeForBackend(cust, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if encoding != "gzip" {
+		t.Errorf("got encoding %q, want gzip", encoding)
+	}
+	got, err := backends.Decode("gzip", body)
+	if err != nil {
+		t.Fatalf("decoding result: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
+
+func TestEncodeForBackendIdentityNeverReEncoded(t *testing.T) {
+	cust := config.Customers{Name: "acme"}
+	p := &backends.Payload{Body: []byte("cpu,host=a value=1"), Encoding: "identity"}
+
+	body, encoding, err := encodeForBackend(cust, p)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if encoding != "" {
+		t.Errorf("got encoding %q, want no Content-Encoding header for identity", encoding)
+	}
+	if string(body) != "cpu,host=a value=1" {
+		t.Errorf("got body %q, want unchanged", body)
+	}
+}